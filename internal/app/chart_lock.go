@@ -0,0 +1,135 @@
+package app
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// lockFileName is the default name of the Helmsman lockfile. As a path it is relative to the
+// current working directory, not to the DSF's directory.
+const lockFileName = "helmsman.lock"
+
+// lockedChart is a single resolved chart entry in the lockfile.
+type lockedChart struct {
+	Name       string `yaml:"name"`
+	Repository string `yaml:"repository"`
+	Version    string `yaml:"version"`
+	Digest     string `yaml:"digest"`
+}
+
+// lockFile is the on-disk format of helmsman.lock: the chart versions/digests resolved from
+// each app's semver constraint, analogous to Helm's requirements.lock for subcharts.
+// get/set are called concurrently from getChartInfo as apps are resolved in parallel, so mu
+// guards Dependencies.
+type lockFile struct {
+	mu           sync.Mutex
+	Dependencies []lockedChart `yaml:"dependencies"`
+}
+
+// chartLock is the lockfile consulted/updated by getChartInfo for the current run.
+var chartLock = &lockFile{}
+
+// chartLockPath is the path chartLock is loaded from and saved to. It defaults to lockFileName
+// in the current working directory, and can be overridden with LoadChartLock before resolving
+// any charts.
+var chartLockPath = lockFileName
+
+// chartLockOnce guards the lazy load performed by ensureChartLockLoaded.
+var chartLockOnce sync.Once
+
+// updateLock, when true (--update-lock), re-resolves every app's chart constraint against the
+// repo index instead of preferring the version already pinned in helmsman.lock.
+var updateLock = false
+
+// ensureChartLockLoaded loads helmsman.lock from chartLockPath into chartLock the first time a
+// chart is resolved, so that versions pinned by a previous run are preferred across runs rather
+// than starting from an empty lock every process.
+func ensureChartLockLoaded() {
+	chartLockOnce.Do(func() {
+		lf, err := loadLockFile(chartLockPath)
+		if err != nil {
+			log.Fatal(fmt.Sprint(err))
+		}
+		chartLock = lf
+	})
+}
+
+// LoadChartLock points chartLock at a custom path (e.g. from a --lock-file flag) and loads it
+// immediately. It must be called before the first chart is resolved.
+func LoadChartLock(path string) error {
+	lf, err := loadLockFile(path)
+	if err != nil {
+		return err
+	}
+	chartLockPath = path
+	chartLock = lf
+	chartLockOnce.Do(func() {}) // ensureChartLockLoaded must not clobber this explicit load
+	return nil
+}
+
+// SaveChartLock persists chartLock back to chartLockPath. getChartInfo calls it after every chart
+// version it resolves from a repo index, so versions resolved this run are pinned for subsequent
+// runs.
+func SaveChartLock() error {
+	return saveLockFile(chartLockPath, chartLock)
+}
+
+// loadLockFile loads the lockfile at path. A missing lockfile is not an error -- an empty
+// lockFile is returned so callers can treat "no lock yet" the same as "empty lock".
+func loadLockFile(path string) (*lockFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &lockFile{}, nil
+		}
+		return nil, fmt.Errorf("while reading lockfile [ %s ]: %s", path, err)
+	}
+
+	lf := &lockFile{}
+	if err := yaml.Unmarshal(data, lf); err != nil {
+		return nil, fmt.Errorf("while parsing lockfile [ %s ]: %s", path, err)
+	}
+	return lf, nil
+}
+
+// saveLockFile writes lf to path, holding lf.mu so concurrent saves and set calls don't race.
+func saveLockFile(path string, lf *lockFile) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	data, err := yaml.Marshal(lf)
+	if err != nil {
+		return fmt.Errorf("while marshaling lockfile: %s", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("while writing lockfile [ %s ]: %s", path, err)
+	}
+	return nil
+}
+
+// get returns the locked entry for repository/name, if any.
+func (lf *lockFile) get(repository, name string) (lockedChart, bool) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	for _, d := range lf.Dependencies {
+		if d.Repository == repository && d.Name == name {
+			return d, true
+		}
+	}
+	return lockedChart{}, false
+}
+
+// set adds or updates the locked entry for repository/name.
+func (lf *lockFile) set(entry lockedChart) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	for i, d := range lf.Dependencies {
+		if d.Repository == entry.Repository && d.Name == entry.Name {
+			lf.Dependencies[i] = entry
+			return
+		}
+	}
+	lf.Dependencies = append(lf.Dependencies, entry)
+}
@@ -0,0 +1,76 @@
+package app
+
+import "testing"
+
+func TestLockFile_GetMissing(t *testing.T) {
+	lf := &lockFile{}
+	if _, ok := lf.get("stable", "nginx"); ok {
+		t.Fatal("expected no entry in an empty lockfile")
+	}
+}
+
+func TestLockFile_SetThenGet(t *testing.T) {
+	lf := &lockFile{}
+	lf.set(lockedChart{Name: "nginx", Repository: "stable", Version: "1.2.0", Digest: "abc"})
+
+	entry, ok := lf.get("stable", "nginx")
+	if !ok {
+		t.Fatal("expected entry after set")
+	}
+	if entry.Version != "1.2.0" || entry.Digest != "abc" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestLockFile_SetOverwritesExisting(t *testing.T) {
+	lf := &lockFile{}
+	lf.set(lockedChart{Name: "nginx", Repository: "stable", Version: "1.2.0", Digest: "abc"})
+	lf.set(lockedChart{Name: "nginx", Repository: "stable", Version: "1.3.0", Digest: "def"})
+
+	if len(lf.Dependencies) != 1 {
+		t.Fatalf("expected a single entry after overwrite, got %d", len(lf.Dependencies))
+	}
+	entry, ok := lf.get("stable", "nginx")
+	if !ok || entry.Version != "1.3.0" || entry.Digest != "def" {
+		t.Fatalf("expected overwritten entry, got %+v", entry)
+	}
+}
+
+func TestLockFile_SetDistinguishesByRepository(t *testing.T) {
+	lf := &lockFile{}
+	lf.set(lockedChart{Name: "nginx", Repository: "stable", Version: "1.2.0"})
+	lf.set(lockedChart{Name: "nginx", Repository: "mirror", Version: "1.5.0"})
+
+	if len(lf.Dependencies) != 2 {
+		t.Fatalf("expected two distinct entries, got %d", len(lf.Dependencies))
+	}
+}
+
+func TestLoadSaveLockFile_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/helmsman.lock"
+
+	lf := &lockFile{Dependencies: []lockedChart{{Name: "nginx", Repository: "stable", Version: "1.2.0", Digest: "abc"}}}
+	if err := saveLockFile(path, lf); err != nil {
+		t.Fatalf("unexpected error saving lockfile: %s", err)
+	}
+
+	loaded, err := loadLockFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading lockfile: %s", err)
+	}
+	entry, ok := loaded.get("stable", "nginx")
+	if !ok || entry.Version != "1.2.0" || entry.Digest != "abc" {
+		t.Fatalf("unexpected round-tripped entry: %+v", entry)
+	}
+}
+
+func TestLoadLockFile_MissingFileIsEmpty(t *testing.T) {
+	lf, err := loadLockFile("/nonexistent/helmsman.lock")
+	if err != nil {
+		t.Fatalf("unexpected error for a missing lockfile: %s", err)
+	}
+	if len(lf.Dependencies) != 0 {
+		t.Fatalf("expected an empty lockfile, got %+v", lf.Dependencies)
+	}
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// helmRepoImportFlag backs the repeatable --helm-repo-import CLI flag: additional
+// "name:url:/path/to/index.yaml" entries to import on top of the DSF's helmRepositoryImports,
+// consumed directly by addHelmRepos.
+var helmRepoImportFlag = kingpin.Flag("helm-repo-import", "Import a pre-fetched Helm repo index.yaml instead of fetching it over the network: name:url:/path/to/index.yaml (repeatable).").Strings()
+
+// updateLockFlag backs --update-lock: re-resolve every app's chart constraint against the repo
+// index instead of preferring the version already pinned in helmsman.lock.
+var updateLockFlag = kingpin.Flag("update-lock", "Re-resolve chart versions against the repo index instead of preferring the versions pinned in helmsman.lock.").Bool()
+
+// lockFileFlag backs --lock-file: the path to read/write the resolved chart lockfile. A relative
+// path (including the default) is resolved against the current working directory, not the DSF's
+// directory.
+var lockFileFlag = kingpin.Flag("lock-file", "Path to the Helmsman lockfile.").Default(lockFileName).String()
+
+// ApplyLockFlags wires --update-lock and --lock-file into the chart lock used by getChartInfo.
+// It should be called once, early in a run, before resolving any charts.
+func ApplyLockFlags() error {
+	updateLock = *updateLockFlag
+	return LoadChartLock(*lockFileFlag)
+}
+
+// chartCacheDirFlag backs --chart-cache-dir: the directory used to cache downloaded chart
+// tarballs. Leaving it unset disables the chart cache and getChartInfo resolves chart metadata
+// straight from the repo index.
+var chartCacheDirFlag = kingpin.Flag("chart-cache-dir", "Directory used to cache downloaded chart tarballs.").String()
+
+// SetChartCacheDir wires --chart-cache-dir into the chart cache consulted by getChartInfo. It
+// should be called once, early in a run, before resolving any charts.
+func SetChartCacheDir() {
+	chartCacheDir = *chartCacheDirFlag
+}
@@ -1,17 +1,29 @@
 package app
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/hashicorp/go-version"
+	chart2 "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
 
+	"github.com/Praqma/helmsman/internal/chartcache"
 	"github.com/Praqma/helmsman/internal/gcs"
 )
 
@@ -20,6 +32,27 @@ type helmRepo struct {
 	Url  string `json:"url"`
 }
 
+// helmRepoImport describes a single pre-fetched repository index that should
+// be imported into the local Helm cache instead of being fetched over the
+// network. It is parsed from a "name:url:/path/to/index.yaml" entry found in
+// the helmRepositoryImports DSF stanza (or the --helm-repo-import flag).
+type helmRepoImport struct {
+	Name string
+	URL  string
+	Path string
+}
+
+var repoImportExtractor = regexp.MustCompile(`^([^:]+):(.+):(/.+)$`)
+
+// parseHelmRepoImport parses a "name:url:/path/to/index.yaml" entry.
+func parseHelmRepoImport(entry string) (helmRepoImport, error) {
+	matches := repoImportExtractor.FindStringSubmatch(entry)
+	if matches == nil {
+		return helmRepoImport{}, fmt.Errorf("invalid helmRepositoryImports entry [ %s ], expected format name:url:/path/to/index.yaml", entry)
+	}
+	return helmRepoImport{Name: matches[1], URL: matches[2], Path: matches[3]}, nil
+}
+
 // helmCmd prepares a helm command to be executed
 func helmCmd(args []string, desc string) Command {
 	return Command{
@@ -29,63 +62,301 @@ func helmCmd(args []string, desc string) Command {
 	}
 }
 
-var versionExtractor = regexp.MustCompile(`[\n]version:\s?(.*)`)
+// ociChartPrefix is the URL scheme identifying an OCI chart reference.
+const ociChartPrefix = "oci://"
+
+// isOCIChart returns true if the chart reference points at an OCI registry.
+func isOCIChart(chart string) bool {
+	return strings.HasPrefix(chart, ociChartPrefix)
+}
 
-// validateChart validates if chart with the same name and version as specified in the DSF exists
+// validateChart validates if chart with the same name and version as specified in the DSF exists.
+// Local charts are inspected on disk, OCI charts (oci://...) are resolved against the registry,
+// and regular repo charts are resolved natively against the repo's cached index.yaml -- no `helm
+// search repo`/`helm show chart` shell-out is required.
 func validateChart(apps, chart, version string, c chan string) {
-	if isLocalChart(chart) {
-		cmd := helmCmd([]string{"inspect", "chart", chart}, "Validating [ "+chart+" ] chart's availability")
+	resolvedChart, err := resolveChartAlias(chart)
+	if err != nil {
+		c <- "Chart [ " + chart + " ] for apps [" + apps + "] is invalid: " + err.Error()
+		return
+	}
+	chart = resolvedChart
 
-		result := cmd.Exec()
-		if result.code != 0 {
+	if isLocalChart(chart) {
+		ci, err := loadLocalChartInfo(chart)
+		if err != nil {
 			maybeRepo := filepath.Base(filepath.Dir(chart))
 			c <- "Chart [ " + chart + " ] for apps [" + apps + "] can't be found. Inspection returned error: \"" +
-				strings.TrimSpace(result.errors) + "\" -- If this is not a local chart, add the repo [ " + maybeRepo + " ] in your helmRepos stanza."
+				err.Error() + "\" -- If this is not a local chart, add the repo [ " + maybeRepo + " ] in your helmRepos stanza."
 			return
 		}
-		matches := versionExtractor.FindStringSubmatch(result.output)
-		if len(matches) == 2 {
-			v := strings.Trim(matches[1], `'"`)
-			if strings.Trim(version, `'"`) != v {
-				c <- "Chart [ " + chart + " ] with version [ " + version + " ] is specified for " +
-					"apps [" + apps + "] but the chart found at that path has version [ " + v + " ] which does not match."
-				return
-			}
-		}
-	} else {
-		v := version
-		if len(v) == 0 {
-			v = "*"
+		v := strings.Trim(ci.Version, `'"`)
+		if strings.Trim(version, `'"`) != v {
+			c <- "Chart [ " + chart + " ] with version [ " + version + " ] is specified for " +
+				"apps [" + apps + "] but the chart found at that path has version [ " + v + " ] which does not match."
+			return
 		}
-		cmd := helmCmd([]string{"search", "repo", chart, "--version", v, "-l"}, "Validating [ "+chart+" ] chart's version [ "+version+" ] availability")
+		return
+	}
 
-		if result := cmd.Exec(); result.code != 0 || strings.Contains(result.output, "No results found") {
+	if isOCIChart(chart) {
+		if _, err := resolveOCIChart(chart, version); err != nil {
 			c <- "Chart [ " + chart + " ] with version [ " + version + " ] is specified for " +
-				"apps [" + apps + "] but was not found. If this is not a local chart, define its helm repo in the helmRepo stanza in your DSF."
-			return
+				"apps [" + apps + "] but was not found: " + err.Error()
 		}
+		return
+	}
+
+	if _, err := resolveChartVersion(chart, version); err != nil {
+		c <- "Chart [ " + chart + " ] with version [ " + version + " ] is specified for " +
+			"apps [" + apps + "] but was not found. If this is not a local chart, define its helm repo in the helmRepo stanza in your DSF."
+		return
 	}
 }
 
-// getChartInfo fetches the latest chart information (name, version) matching the semantic versioning constraints.
+// getChartInfo fetches the chart information (name, version, ...) matching the semantic
+// versioning constraints, resolved natively from the repo index / OCI registry / local chart
+// instead of shelling out to `helm show chart`.
 func getChartInfo(chart, version string) (*chartInfo, error) {
+	chart, err := resolveChartAlias(chart)
+	if err != nil {
+		return nil, err
+	}
+
 	if isLocalChart(chart) {
 		log.Info("Chart [ " + chart + " ] with version [ " + version + " ] was found locally.")
+		return loadLocalChartInfo(chart)
 	}
 
-	cmd := helmCmd([]string{"show", "chart", chart, "--version", version}, "Getting latest non-local chart's version "+chart+"-"+version+"")
+	if isOCIChart(chart) {
+		md, err := resolveOCIChart(chart, version)
+		if err != nil {
+			return nil, fmt.Errorf("Chart [ %s ] with version [ %s ] is specified but not found in the OCI registry: %s", chart, version, err)
+		}
+		return metadataToChartInfo(md)
+	}
 
-	result := cmd.Exec()
-	if result.code != 0 {
+	repoName, chartName, err := splitChartRef(chart)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureChartLockLoaded()
+	resolveVersion := version
+	if locked, ok := chartLock.get(repoName, chartName); ok && !updateLock {
+		resolveVersion = locked.Version
+	}
+
+	cv, err := resolveChartVersion(chart, resolveVersion)
+	if err != nil {
 		return nil, fmt.Errorf("Chart [ %s ] with version [ %s ] is specified but not found in the helm repositories", chart, version)
 	}
 
-	c := &chartInfo{}
-	if err := yaml.Unmarshal([]byte(result.output), &c); err != nil {
-		log.Fatal(fmt.Sprint(err))
+	chartLock.set(lockedChart{Name: chartName, Repository: repoName, Version: cv.Version, Digest: cv.Digest})
+	if err := SaveChartLock(); err != nil {
+		return nil, fmt.Errorf("while persisting resolved chart version to the lockfile: %s", err)
+	}
+
+	if chartCacheDir == "" {
+		return metadataToChartInfo(cv.Metadata)
+	}
+
+	cachedPath, err := fetchCachedChart(repoName, chartName, cv)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := loader.Load(cachedPath)
+	if err != nil {
+		return nil, err
+	}
+	return metadataToChartInfo(ch.Metadata)
+}
+
+// chartCacheDir is the directory used to cache downloaded chart tarballs, set from the
+// --chart-cache-dir flag. When empty, getChartInfo resolves chart metadata straight from the
+// repo index without downloading the chart tarball.
+var chartCacheDir string
+
+// fetchCachedChart downloads (or reuses a cached copy of) the chart tarball described by cv via
+// the chartcache subsystem, which de-duplicates concurrent fetches of the same chart across apps
+// and verifies the tarball against the digest recorded in the repo index.
+func fetchCachedChart(repoName, chartName string, cv *repo.ChartVersion) (string, error) {
+	if len(cv.URLs) == 0 {
+		return "", fmt.Errorf("chart [ %s/%s ] has no download URL in its repo index", repoName, chartName)
+	}
+
+	// Index entries are commonly published with URLs relative to the index itself, the same
+	// case Helm's own pkg/downloader.Manager handles by resolving against the repo's base URL.
+	chartURL, err := repo.ResolveReferenceURL(configuredHelmRepos[repoName], cv.URLs[0])
+	if err != nil {
+		return "", fmt.Errorf("while resolving download URL for chart [ %s/%s ]: %s", repoName, chartName, err)
+	}
+
+	return chartcache.Fetch(chartCacheDir, repoName, chartName, cv.Version, cv.Digest, func() ([]byte, error) {
+		resp, err := http.Get(chartURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status [ %d ] downloading chart from [ %s ]", resp.StatusCode, chartURL)
+		}
+		return ioutil.ReadAll(resp.Body)
+	})
+}
+
+// loadLocalChartInfo loads a local chart directory or archive and returns its metadata as a chartInfo.
+func loadLocalChartInfo(chart string) (*chartInfo, error) {
+	ch, err := loader.Load(chart)
+	if err != nil {
+		return nil, err
+	}
+	return metadataToChartInfo(ch.Metadata)
+}
+
+// configuredHelmRepos holds the repoName -> URL mapping from the DSF's helmRepos stanza,
+// populated by addHelmRepos so that alias chart references can be resolved against it.
+var configuredHelmRepos = map[string]string{}
+
+// resolveChartAlias rewrites an "@repoName/chartName" or "alias:repoName/chartName" chart
+// reference into the canonical "repoName/chartName" form expected by helm, resolving repoName
+// against the helmRepos stanza. If that repo's URL is an oci:// URL, the alias is instead rewritten
+// to the full "oci://host/path/chartName" reference (without the URL's userinfo). References that
+// don't use the alias syntax are returned as-is.
+func resolveChartAlias(chart string) (string, error) {
+	var rest string
+	switch {
+	case strings.HasPrefix(chart, "@"):
+		rest = strings.TrimPrefix(chart, "@")
+	case strings.HasPrefix(chart, "alias:"):
+		rest = strings.TrimPrefix(chart, "alias:")
+	default:
+		return chart, nil
+	}
+
+	repoName, chartName, err := splitChartRef(rest)
+	if err != nil {
+		return "", fmt.Errorf("invalid chart alias reference [ %s ]: %s", chart, err)
+	}
+	repoURL, ok := configuredHelmRepos[repoName]
+	if !ok {
+		return "", fmt.Errorf("chart [ %s ] references repo alias [ %s ] which is not defined in your helmRepos stanza", chart, repoName)
+	}
+
+	if strings.HasPrefix(repoURL, ociChartPrefix) {
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid URL for helm repo [ %s ]: %s", repoName, err)
+		}
+		return ociChartPrefix + strings.TrimSuffix(u.Host+u.Path, "/") + "/" + chartName, nil
+	}
+
+	return repoName + "/" + chartName, nil
+}
+
+// splitChartRef splits a "repoName/chartName" reference into its parts.
+func splitChartRef(chart string) (repoName, chartName string, err error) {
+	parts := strings.SplitN(chart, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("chart reference [ %s ] must be in the form repoName/chartName", chart)
+	}
+	return parts[0], parts[1], nil
+}
+
+// resolveChartVersion resolves chart's version (a concrete version or a semver constraint such as
+// "^1.2.0") against the cached index.yaml of the chart's repo, without contacting the repo.
+func resolveChartVersion(chart, version string) (*repo.ChartVersion, error) {
+	repoName, chartName, err := splitChartRef(chart)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := repo.LoadIndexFile(helmpath.CacheIndexFile(repoName))
+	if err != nil {
+		return nil, fmt.Errorf("while loading index for helm repo [ %s ]: %s", repoName, err)
+	}
+
+	versions, ok := idx.Entries[chartName]
+	if !ok || len(versions) == 0 {
+		return nil, fmt.Errorf("chart [ %s ] not found in helm repo [ %s ]", chartName, repoName)
+	}
+
+	cv, err := matchVersion(versions, version)
+	if err != nil {
+		return nil, fmt.Errorf("%s in helm repo [ %s ]", err, repoName)
+	}
+	return cv, nil
+}
+
+// matchVersion picks the chart version among versions that satisfies version, which may be a
+// concrete version, a semver constraint (e.g. "^1.2.0"), or empty (meaning "latest", i.e. the
+// first entry -- repo.IndexFile sorts entries newest-first).
+func matchVersion(versions []*repo.ChartVersion, version string) (*repo.ChartVersion, error) {
+	if len(versions) == 0 {
+		return nil, errors.New("no chart versions available")
+	}
+
+	version = strings.Trim(version, `'"`)
+	if version == "" {
+		return versions[0], nil
+	}
+
+	if constraint, err := semver.NewConstraint(version); err == nil {
+		for _, cv := range versions {
+			v, err := semver.NewVersion(cv.Version)
+			if err != nil {
+				continue
+			}
+			if constraint.Check(v) {
+				return cv, nil
+			}
+		}
+		return nil, fmt.Errorf("no version of chart [ %s ] satisfies constraint [ %s ]", versions[0].Name, version)
+	}
+
+	for _, cv := range versions {
+		if cv.Version == version {
+			return cv, nil
+		}
+	}
+	return nil, fmt.Errorf("chart [ %s ] with version [ %s ] not found", versions[0].Name, version)
+}
+
+// resolveOCIChart resolves chart's (or version's) metadata directly from the OCI registry.
+func resolveOCIChart(chart, version string) (*chart2.Metadata, error) {
+	client, err := registry.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("while creating OCI registry client: %s", err)
+	}
+
+	ref := strings.TrimPrefix(chart, ociChartPrefix)
+	if version = strings.Trim(version, `'"`); version != "" {
+		ref = ref + ":" + version
+	}
+
+	result, err := client.Pull(ref, registry.PullOptWithChart(true))
+	if err != nil {
+		return nil, err
 	}
 
-	return c, nil
+	ch, err := loader.LoadArchive(bytes.NewReader(result.Chart.Data))
+	if err != nil {
+		return nil, err
+	}
+	return ch.Metadata, nil
+}
+
+// metadataToChartInfo converts a chart's Chart.yaml metadata into a chartInfo.
+func metadataToChartInfo(md *chart2.Metadata) (*chartInfo, error) {
+	raw, err := yaml.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	ci := &chartInfo{}
+	if err := yaml.Unmarshal(raw, ci); err != nil {
+		log.Fatal(fmt.Sprint(err))
+	}
+	return ci, nil
 }
 
 // getHelmClientVersion returns Helm client Version
@@ -139,9 +410,104 @@ func updateChartDep(chartPath string) error {
 	return nil
 }
 
+// importHelmRepoIndexes installs one or more pre-fetched index.yaml files directly
+// into Helm's repository cache and records them in Helm's repositories.yaml, without
+// contacting the upstream repos. This is primarily useful in air-gapped/CI environments
+// and when running many parallel Helmsman invocations against the same repos, where
+// repeated `helm repo add`/`helm repo update` calls are slow or rate-limited.
+// forceUpdate mirrors the --force-update semantics of addHelmRepos: if an import
+// references a repo name that is already configured with a different URL, the import
+// is rejected unless forceUpdate is set.
+func importHelmRepoIndexes(imports []string, forceUpdate bool) error {
+	if len(imports) == 0 {
+		return nil
+	}
+
+	repoFile := helmpath.ConfigPath("repositories.yaml")
+	f, err := repo.LoadFile(repoFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("while loading Helm repository file: %s", err)
+		}
+		f = repo.NewFile()
+	}
+
+	for _, entry := range imports {
+		imp, err := parseHelmRepoImport(entry)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(imp.Path); err != nil {
+			return fmt.Errorf("helmRepositoryImports entry [ %s ]: index file not found: %s", imp.Name, err)
+		}
+
+		if existing := f.Get(imp.Name); existing != nil && existing.URL != imp.URL && !forceUpdate {
+			return fmt.Errorf("repo [ %s ] is already imported from [ %s ]; pass --force-update to import from [ %s ] instead", imp.Name, existing.URL, imp.URL)
+		}
+
+		cachedIndex := helmpath.CacheIndexFile(imp.Name)
+		if err := copyFile(imp.Path, cachedIndex); err != nil {
+			return fmt.Errorf("while importing index for repo [ %s ]: %s", imp.Name, err)
+		}
+
+		f.Update(&repo.Entry{Name: imp.Name, URL: imp.URL})
+		log.Info("Imported helm repository [ " + imp.Name + " ] from local index [ " + imp.Path + " ]")
+	}
+
+	if err := f.WriteFile(repoFile, 0644); err != nil {
+		return fmt.Errorf("while writing Helm repository file: %s", err)
+	}
+
+	return nil
+}
+
+// copyFile copies src to dst, overwriting dst if it already exists.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
 // addHelmRepos adds repositories to Helm if they don't exist already.
 // Helm does not mind if a repo with the same name exists. It treats it as an update.
-func addHelmRepos(repos map[string]string) error {
+// repoImports are "name:url:/path/to/index.yaml" entries -- from the DSF's
+// helmRepositoryImports stanza and/or repeated --helm-repo-import flags -- that are imported
+// directly into Helm's repository cache via importHelmRepoIndexes before any repo is added over
+// the network.
+func addHelmRepos(repos map[string]string, repoImports []string) error {
+	repoAddFlags := ""
+	if checkHelmVersion(">=3.3.2") {
+		repoAddFlags = "--force-update"
+	}
+
+	allImports := append(append([]string{}, repoImports...), *helmRepoImportFlag...)
+	if err := importHelmRepoIndexes(allImports, repoAddFlags != ""); err != nil {
+		return err
+	}
+
+	if err := ApplyLockFlags(); err != nil {
+		return fmt.Errorf("while applying lockfile flags: %s", err)
+	}
+	SetChartCacheDir()
+
+	configuredHelmRepos = repos
+
 	var helmRepos []helmRepo
 	existingRepos := make(map[string]string)
 
@@ -161,11 +527,6 @@ func addHelmRepos(repos map[string]string) error {
 		}
 	}
 
-	repoAddFlags := ""
-	if checkHelmVersion(">=3.3.2") {
-		repoAddFlags += "--force-update"
-	}
-
 	for repoName, repoLink := range repos {
 		basicAuthArgs := []string{}
 		// check if repo is in GCS, then perform GCS auth -- needed for private GCS helm repos
@@ -184,6 +545,25 @@ func addHelmRepos(repos map[string]string) error {
 		if err != nil {
 			log.Fatal("failed to add helm repo:  " + err.Error())
 		}
+
+		if strings.HasPrefix(repoLink, ociChartPrefix) {
+			// OCI registries aren't tracked via `helm repo add`/repositories.yaml -- they are
+			// authenticated to directly, using credentials parsed from the URL userinfo.
+			username, password := "", ""
+			if u.User != nil {
+				username = u.User.Username()
+				password, _ = u.User.Password()
+			}
+			client, err := registry.NewClient()
+			if err != nil {
+				return fmt.Errorf("while creating OCI registry client for repo [ %s ]: %s", repoName, err)
+			}
+			if err := client.Login(u.Host, registry.LoginOptBasicAuth(username, password)); err != nil {
+				return fmt.Errorf("while logging into OCI registry [ %s ]: %s", repoName, err)
+			}
+			continue
+		}
+
 		if u.User != nil {
 			p, ok := u.User.Password()
 			if !ok {
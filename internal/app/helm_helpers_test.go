@@ -0,0 +1,60 @@
+package app
+
+import (
+	"testing"
+
+	chart2 "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func chartVersions(versions ...string) []*repo.ChartVersion {
+	cvs := make([]*repo.ChartVersion, len(versions))
+	for i, v := range versions {
+		cvs[i] = &repo.ChartVersion{Metadata: &chart2.Metadata{Name: "demo", Version: v}}
+	}
+	return cvs
+}
+
+func TestMatchVersion_Constraint(t *testing.T) {
+	versions := chartVersions("2.0.0", "1.5.0", "1.2.0", "1.0.0")
+
+	cv, err := matchVersion(versions, "^1.2.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cv.Version != "1.5.0" {
+		t.Fatalf("expected 1.5.0 to satisfy ^1.2.0, got %s", cv.Version)
+	}
+}
+
+func TestMatchVersion_ExactFallback(t *testing.T) {
+	versions := chartVersions("2.0.0", "1.5.0")
+
+	cv, err := matchVersion(versions, "1.5.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cv.Version != "1.5.0" {
+		t.Fatalf("expected exact match 1.5.0, got %s", cv.Version)
+	}
+}
+
+func TestMatchVersion_Latest(t *testing.T) {
+	versions := chartVersions("2.0.0", "1.5.0")
+
+	cv, err := matchVersion(versions, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cv.Version != "2.0.0" {
+		t.Fatalf("expected latest (first) entry 2.0.0, got %s", cv.Version)
+	}
+}
+
+func TestMatchVersion_NoSatisfyingVersion(t *testing.T) {
+	versions := chartVersions("1.0.0")
+
+	if _, err := matchVersion(versions, "^2.0.0"); err == nil {
+		t.Fatal("expected an error when no version satisfies the constraint")
+	}
+}
@@ -0,0 +1,86 @@
+// Package chartcache implements a concurrent, on-disk cache of downloaded chart tarballs, keyed
+// by (repo, name, version, digest). It mirrors Helm's own pkg/downloader.Manager approach: many
+// apps in a DSF referencing the same chart trigger a single download, and cached charts enable a
+// real offline mode when combined with repository index imports.
+package chartcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// key identifies a single chart tarball in the cache.
+type key struct {
+	repo, name, version, digest string
+}
+
+// fetchLocks serializes concurrent fetches of the same key (a per-key singleflight lock) so
+// that N apps referencing the same chart trigger exactly one download.
+var fetchLocks sync.Map // map[key]*sync.Mutex
+
+func lockFor(k key) *sync.Mutex {
+	mu, _ := fetchLocks.LoadOrStore(k, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// chartPath returns the on-disk path for the cached tarball of k under dir. The digest is part of
+// the file name, so a tarball cached under one digest is never served for another.
+func chartPath(dir string, k key) string {
+	file := k.version + ".tgz"
+	if k.digest != "" {
+		file = k.version + "-" + k.digest + ".tgz"
+	}
+	return filepath.Join(dir, k.repo, k.name, file)
+}
+
+// Fetch returns the local path to the chart tarball for (repo, name, version, digest), downloading
+// it via fetch if it isn't already cached. dir is the chart cache directory (wired from
+// --chart-cache-dir by the caller) and must be non-empty. Concurrent calls for the same key block
+// on each other rather than downloading the same chart in parallel. When digest is non-empty, the
+// downloaded tarball's SHA256 is verified against it before being admitted to the cache.
+func Fetch(dir, repo, name, version, digest string, fetch func() ([]byte, error)) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("no chart cache directory configured; pass --chart-cache-dir")
+	}
+
+	k := key{repo: repo, name: name, version: version, digest: digest}
+	mu := lockFor(k)
+	mu.Lock()
+	defer mu.Unlock()
+
+	dst := chartPath(dir, k)
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	}
+
+	data, err := fetch()
+	if err != nil {
+		return "", fmt.Errorf("while fetching chart [ %s/%s-%s ]: %s", repo, name, version, err)
+	}
+
+	if digest != "" {
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); got != digest {
+			return "", fmt.Errorf("chart [ %s/%s-%s ] digest mismatch: expected [ %s ], got [ %s ]", repo, name, version, digest, got)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", fmt.Errorf("while creating chart cache directory: %s", err)
+	}
+
+	tmp := dst + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return "", fmt.Errorf("while writing cached chart [ %s/%s-%s ]: %s", repo, name, version, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return "", fmt.Errorf("while finalizing cached chart [ %s/%s-%s ]: %s", repo, name, version, err)
+	}
+
+	return dst, nil
+}
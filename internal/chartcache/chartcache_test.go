@@ -0,0 +1,101 @@
+package chartcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetch_DedupesConcurrentCallers(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("chart contents")
+	var calls int32
+
+	fetch := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return data, nil
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			if _, err := Fetch(dir, "stable", "nginx", "1.2.0", "", fetch); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected exactly 1 download for concurrent callers of the same key, got %d", got)
+	}
+}
+
+func TestFetch_ReturnsCachedPathWithoutRefetching(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("chart contents")
+	var calls int32
+	fetch := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return data, nil
+	}
+
+	path1, err := Fetch(dir, "stable", "nginx", "1.2.0", "", fetch)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	path2, err := Fetch(dir, "stable", "nginx", "1.2.0", "", fetch)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if path1 != path2 {
+		t.Fatalf("expected the same cached path, got %q and %q", path1, path2)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected a cached second call to skip fetch, got %d downloads", got)
+	}
+}
+
+func TestFetch_DigestMismatchIsRejected(t *testing.T) {
+	dir := t.TempDir()
+	fetch := func() ([]byte, error) {
+		return []byte("chart contents"), nil
+	}
+
+	_, err := Fetch(dir, "stable", "nginx", "1.2.0", "0000000000000000000000000000000000000000000000000000000000000000", fetch)
+	if err == nil {
+		t.Fatal("expected a digest mismatch error")
+	}
+}
+
+func TestFetch_DigestMatchIsAccepted(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("chart contents")
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	path, err := Fetch(dir, "stable", "nginx", "1.2.0", digest, func() ([]byte, error) {
+		return data, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error for a matching digest: %s", err)
+	}
+	if path == "" {
+		t.Fatal("expected a non-empty cached path")
+	}
+}
+
+func TestFetch_RequiresCacheDir(t *testing.T) {
+	_, err := Fetch("", "stable", "nginx", "1.2.0", "", func() ([]byte, error) {
+		return []byte("x"), nil
+	})
+	if err == nil {
+		t.Fatal("expected an error when no cache directory is configured")
+	}
+}